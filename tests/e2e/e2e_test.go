@@ -19,10 +19,14 @@ package e2e
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
 )
 
 // Test context
@@ -34,18 +38,65 @@ var (
 	imageRepo string
 	imageTag  string
 
-	sshKey     string
-	sshUser    string
-	remoteHost string
+	runtime string
+
+	sshKey            string
+	sshUser           string
+	remoteHost        string
+	sshKnownHosts     string
+	sshConnectRetries int
+	sshConnectBackoff time.Duration
+
+	provisionerKind string
+	provisioner     Provisioner
+
+	hetznerToken      string
+	hetznerServerType string
+	hetznerLocation   string
+	hetznerImage      string
+
+	qemuImage  string
+	qemuMemory string
+
+	debugOnFailure     bool
+	debugAuthorizedKey string
+	debugPort          int
+	debugTriggered     bool
+
+	// installWorkDir is the toolkit's scratch directory on the installed
+	// host, set by BeforeSuite when -install-ctk is given.
+	installWorkDir string
+
+	// activeDebugGateway is the gateway opened by the most recent failed
+	// spec, if any. AfterEach stops it before opening a new one so that a
+	// suite with more than one failing spec doesn't try to bind debugPort
+	// twice.
+	activeDebugGateway *DebugGateway
 )
 
 func init() {
 	flag.BoolVar(&installCTK, "install-ctk", false, "Install the NVIDIA Container Toolkit")
 	flag.StringVar(&imageRepo, "image-repo", "", "Repository of the image to test")
 	flag.StringVar(&imageTag, "image-tag", "", "Tag of the image to test")
+	flag.StringVar(&runtime, "runtime", string(RuntimeDocker), "Container runtime to install and configure the toolkit for (docker, containerd, crio, podman)")
 	flag.StringVar(&sshKey, "ssh-key", "", "SSH key to use for remote login")
 	flag.StringVar(&sshUser, "ssh-user", "", "SSH user to use for remote login")
 	flag.StringVar(&remoteHost, "remote-host", "", "Hostname of the remote machine")
+	flag.StringVar(&sshKnownHosts, "ssh-known-hosts", "", "Path to an OpenSSH known_hosts file used to verify the remote host key (defaults to ~/.ssh/ctk_e2e_known_hosts, trusting new hosts on first connect)")
+	flag.IntVar(&sshConnectRetries, "ssh-connect-retries", 20, "Number of times to retry the initial SSH connection to the remote host")
+	flag.DurationVar(&sshConnectBackoff, "ssh-connect-backoff", 1*time.Second, "Time to wait between SSH connection retries")
+
+	flag.StringVar(&provisionerKind, "provisioner", string(ProvisionerNone), "Backend used to provision the test host (none, hetzner, qemu)")
+	flag.StringVar(&hetznerToken, "hetzner-token", os.Getenv("HCLOUD_TOKEN"), "Hetzner Cloud API token (defaults to $HCLOUD_TOKEN)")
+	flag.StringVar(&hetznerServerType, "hetzner-server-type", "cpx31", "Hetzner Cloud server type to provision")
+	flag.StringVar(&hetznerLocation, "hetzner-location", "fsn1", "Hetzner Cloud location to provision the server in")
+	flag.StringVar(&hetznerImage, "hetzner-image", "ubuntu-22.04", "Hetzner Cloud image to boot the server from")
+	flag.StringVar(&qemuImage, "qemu-image", "", "Path to the qemu disk image to boot")
+	flag.StringVar(&qemuMemory, "qemu-memory", "4G", "Amount of memory to give the qemu VM")
+
+	flag.BoolVar(&debugOnFailure, "debug-on-failure", false, "Keep the remote host alive and open an SSH debug gateway into it when a spec fails")
+	flag.StringVar(&debugAuthorizedKey, "debug-authorized-key", "", "Authorized public key (as in authorized_keys) allowed to connect to the debug gateway")
+	flag.IntVar(&debugPort, "debug-port", 2345, "Port on the remote host the debug gateway listens on")
 }
 
 func TestMain(t *testing.T) {
@@ -60,4 +111,70 @@ func TestMain(t *testing.T) {
 // BeforeSuite runs before the test suite
 var _ = BeforeSuite(func() {
 	ctx = context.Background()
+
+	var err error
+	provisioner, err = newProvisioner(ProvisionerKind(provisionerKind), ProvisionerOptions{
+		RemoteHost:        remoteHost,
+		SshUser:           sshUser,
+		SshKey:            sshKey,
+		HetznerToken:      hetznerToken,
+		HetznerServerType: hetznerServerType,
+		HetznerLocation:   hetznerLocation,
+		HetznerImage:      hetznerImage,
+		QemuImage:         qemuImage,
+		QemuMemory:        qemuMemory,
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	remoteHost, sshUser, sshKey, err = provisioner.Create(ctx)
+	Expect(err).ToNot(HaveOccurred())
+
+	if installCTK {
+		installer, err := newInstaller(Runtime(runtime), fmt.Sprintf("%s:%s", imageRepo, imageTag), sshKey, sshUser, remoteHost, sshKnownHosts, sshConnectRetries, sshConnectBackoff)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = installer.Install(ctx, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+
+		installWorkDir = installer.WorkDir
+	}
+})
+
+// AfterEach opens a debug gateway into the remote host when a spec fails and
+// -debug-on-failure was given, so a developer can attach and inspect the
+// exact failed state.
+var _ = AfterEach(func() {
+	if !debugOnFailure || !CurrentSpecReport().Failed() {
+		return
+	}
+
+	if activeDebugGateway != nil {
+		activeDebugGateway.Stop()
+		activeDebugGateway = nil
+	}
+
+	var client *ssh.Client
+	if remoteHost != "" {
+		var err error
+		client, err = connectOrDie(sshKey, sshUser, remoteHost, sshKnownHosts, sshConnectRetries, sshConnectBackoff)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	gateway, err := newDebugGateway(client, debugPort, installWorkDir, debugAuthorizedKey)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(gateway.Start(ctx)).To(Succeed())
+	activeDebugGateway = gateway
+
+	debugTriggered = true
+	fmt.Fprintf(GinkgoWriter, "spec failed: leaving %s alive, debug gateway listening on port %d\n", remoteHost, debugPort)
+})
+
+// AfterSuite runs after the test suite, tearing down any host provisioned in
+// BeforeSuite, unless a failure left the host up for debugging.
+var _ = AfterSuite(func() {
+	if debugTriggered {
+		fmt.Fprintf(GinkgoWriter, "leaving %s alive for debugging, skipping teardown\n", remoteHost)
+		return
+	}
+	Expect(provisioner.Destroy(ctx)).To(Succeed())
 })
@@ -0,0 +1,346 @@
+/*
+* Copyright (c) 2025, NVIDIA CORPORATION.  All rights reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/crypto/ssh"
+)
+
+// knownDebugSockets maps the logical socket names a debug client can ask to
+// be forwarded to their path on the remote host.
+var knownDebugSockets = map[string]string{
+	"docker.sock":     "/var/run/docker.sock",
+	"containerd.sock": "/run/containerd/containerd.sock",
+}
+
+// DebugGateway keeps a failed spec's remote host alive and exposes an
+// interactive SSH gateway into it so a developer can attach and re-run
+// commands against the exact failed state. It reuses the SSH connection
+// already established to drive the host under test (see remoteRun) to open
+// a reverse tunnel: connections to RemotePort on the remote host are routed
+// back to an SSH server running in this process.
+type DebugGateway struct {
+	RemotePort    int
+	AuthorizedKey ssh.PublicKey
+	WorkDir       string
+
+	// client is the existing connection to the host under test. A nil
+	// client means the install ran locally, so sessions are served by a
+	// locally allocated PTY instead of being proxied to a remote host.
+	client *ssh.Client
+
+	listener net.Listener
+	cancel   context.CancelFunc
+}
+
+// newDebugGateway builds a DebugGateway. client may be nil when the suite is
+// exercising a local install, in which case debug sessions run against the
+// local machine instead of being tunnelled to a remote host.
+func newDebugGateway(client *ssh.Client, remotePort int, workDir, authorizedKeyLine string) (*DebugGateway, error) {
+	authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKeyLine))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse -debug-authorized-key: %w", err)
+	}
+
+	return &DebugGateway{
+		RemotePort:    remotePort,
+		AuthorizedKey: authorizedKey,
+		WorkDir:       workDir,
+		client:        client,
+	}, nil
+}
+
+// Start requests a reverse tunnel bound to RemotePort on the remote host and
+// begins serving an SSH server over it until Stop is called or ctx is
+// cancelled.
+func (g *DebugGateway) Start(ctx context.Context) error {
+	hostKey, err := newEphemeralHostKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate ephemeral host key: %w", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), g.AuthorizedKey.Marshal()) {
+				return nil, fmt.Errorf("unrecognized public key for %q", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := g.listen()
+	if err != nil {
+		return fmt.Errorf("failed to bind debug gateway on port %d: %w", g.RemotePort, err)
+	}
+	g.listener = listener
+
+	runCtx, cancel := context.WithCancel(ctx)
+	g.cancel = cancel
+	go func() {
+		<-runCtx.Done()
+		listener.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go g.serveConn(conn, config)
+		}
+	}()
+
+	return nil
+}
+
+// Stop tears down the reverse tunnel and stops accepting debug connections.
+func (g *DebugGateway) Stop() {
+	if g.cancel != nil {
+		g.cancel()
+	}
+}
+
+// listen binds RemotePort on the remote host (tunnelled back to us over the
+// existing client) or, for a local install, on localhost directly.
+func (g *DebugGateway) listen() (net.Listener, error) {
+	addr := fmt.Sprintf("0.0.0.0:%d", g.RemotePort)
+	if g.client == nil {
+		return net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", g.RemotePort))
+	}
+	return g.client.Listen("tcp", addr)
+}
+
+func (g *DebugGateway) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go g.handleSession(newChannel)
+		case "direct-tcpip":
+			go g.handleDirectTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// handleSession services an interactive "bash" request, either by proxying
+// a new PTY session over the existing remote connection or, when there is
+// no remote host, by allocating a local PTY.
+func (g *DebugGateway) handleSession(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	go func() {
+		for req := range requests {
+			switch req.Type {
+			case "pty-req", "shell":
+				req.Reply(true, nil)
+			default:
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	if g.client != nil {
+		g.proxyRemoteShell(channel)
+		return
+	}
+	g.runLocalShell(channel)
+}
+
+// proxyRemoteShell opens a fresh session over the existing connection to the
+// host under test, requests a PTY rooted at WorkDir, and starts bash.
+func (g *DebugGateway) proxyRemoteShell(channel ssh.Channel) {
+	session, err := g.client.NewSession()
+	if err != nil {
+		fmt.Fprintf(channel, "failed to open remote session: %v\r\n", err)
+		return
+	}
+	defer session.Close()
+
+	if err := session.RequestPty("xterm", 40, 160, ssh.TerminalModes{}); err != nil {
+		fmt.Fprintf(channel, "failed to allocate remote pty: %v\r\n", err)
+		return
+	}
+
+	session.Stdin = channel
+	session.Stdout = channel
+	session.Stderr = channel
+
+	cmd := "bash"
+	if g.WorkDir != "" {
+		cmd = fmt.Sprintf("cd %q && exec bash", g.WorkDir)
+	}
+	if err := session.Run(cmd); err != nil {
+		fmt.Fprintf(channel, "remote shell exited: %v\r\n", err)
+	}
+}
+
+// runLocalShell allocates a local PTY and runs bash against it, used when
+// debugging an install that ran on the local machine.
+func (g *DebugGateway) runLocalShell(channel ssh.Channel) {
+	cmd := exec.Command("bash")
+	if g.WorkDir != "" {
+		cmd.Dir = g.WorkDir
+	}
+
+	f, err := pty.Start(cmd)
+	if err != nil {
+		fmt.Fprintf(channel, "failed to start local pty: %v\r\n", err)
+		return
+	}
+	defer f.Close()
+
+	go io.Copy(f, channel)
+	io.Copy(channel, f)
+	cmd.Wait()
+}
+
+// handleDirectTCPIP forwards a developer's local port-forward request. A
+// destination of "docker.sock" or "containerd.sock" is translated to the
+// corresponding unix socket on the host under test; anything else is dialled
+// as a regular TCP forward over the existing connection.
+func (g *DebugGateway) handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var req struct {
+		DestAddr string
+		DestPort uint32
+		SrcAddr  string
+		SrcPort  uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &req); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "malformed forward request")
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	if socketPath, ok := knownDebugSockets[req.DestAddr]; ok {
+		var remoteConn net.Conn
+		if g.client != nil {
+			remoteConn, err = dialRemoteUnix(g.client, socketPath)
+		} else {
+			remoteConn, err = net.Dial("unix", socketPath)
+		}
+		if err != nil {
+			fmt.Fprintf(channel, "failed to dial %s: %v\r\n", socketPath, err)
+			return
+		}
+		defer remoteConn.Close()
+		proxy(channel, remoteConn)
+		return
+	}
+
+	addr := fmt.Sprintf("%s:%d", req.DestAddr, req.DestPort)
+	var remoteConn net.Conn
+	if g.client != nil {
+		remoteConn, err = g.client.Dial("tcp", addr)
+	} else {
+		remoteConn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		fmt.Fprintf(channel, "failed to dial %s: %v\r\n", addr, err)
+		return
+	}
+	defer remoteConn.Close()
+	proxy(channel, remoteConn)
+}
+
+// proxy copies data in both directions until either side closes.
+func proxy(a io.ReadWriteCloser, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(a, b); done <- struct{}{} }()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
+
+// dialRemoteUnix opens a unix domain socket on the remote end of client
+// using the OpenSSH direct-streamlocal@openssh.com extension, which the
+// standard library ssh.Client.Dial does not support directly.
+func dialRemoteUnix(client *ssh.Client, socketPath string) (net.Conn, error) {
+	type streamLocalChannelOpenDirectMsg struct {
+		SocketPath string
+		Reserved0  string
+		Reserved1  uint32
+	}
+
+	msg := streamLocalChannelOpenDirectMsg{SocketPath: socketPath}
+	channel, requests, err := client.OpenChannel("direct-streamlocal@openssh.com", ssh.Marshal(&msg))
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(requests)
+
+	return &channelConn{Channel: channel}, nil
+}
+
+// channelConn adapts an ssh.Channel to the net.Conn interface expected by
+// the rest of the proxying code. Deadlines are not supported by ssh.Channel
+// and are silently ignored.
+type channelConn struct {
+	ssh.Channel
+}
+
+func (c *channelConn) LocalAddr() net.Addr                { return debugAddr{} }
+func (c *channelConn) RemoteAddr() net.Addr               { return debugAddr{} }
+func (c *channelConn) SetDeadline(t time.Time) error      { return nil }
+func (c *channelConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *channelConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type debugAddr struct{}
+
+func (debugAddr) Network() string { return "ssh-channel" }
+func (debugAddr) String() string  { return "ssh-channel" }
+
+// newEphemeralHostKey generates a throwaway RSA host key used only for the
+// lifetime of a single debug gateway.
+func newEphemeralHostKey() (ssh.Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
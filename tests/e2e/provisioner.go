@@ -0,0 +1,311 @@
+/*
+* Copyright (c) 2025, NVIDIA CORPORATION.  All rights reserved.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*     http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ProvisionerKind selects the backend used to stand up the ephemeral host
+// that the e2e suite runs against.
+type ProvisionerKind string
+
+const (
+	ProvisionerNone    ProvisionerKind = "none"
+	ProvisionerHetzner ProvisionerKind = "hetzner"
+	ProvisionerQemu    ProvisionerKind = "qemu"
+)
+
+// Provisioner creates and destroys the host that the e2e suite is run
+// against. BeforeSuite calls Create once and AfterSuite calls Destroy,
+// mirroring the lifetime of a single test run.
+type Provisioner interface {
+	// Create provisions a host and returns the address and SSH
+	// credentials needed to reach it.
+	Create(ctx context.Context) (host, sshUser, sshKey string, err error)
+	// Destroy tears down the host created by Create.
+	Destroy(ctx context.Context) error
+}
+
+// ProvisionerOptions bundles the flag-derived configuration for whichever
+// Provisioner implementation is selected.
+type ProvisionerOptions struct {
+	// Used by the "none" provisioner.
+	RemoteHost string
+	SshUser    string
+	SshKey     string
+
+	// Used by the "hetzner" provisioner.
+	HetznerToken      string
+	HetznerServerType string
+	HetznerLocation   string
+	HetznerImage      string
+
+	// Used by the "qemu" provisioner.
+	QemuImage  string
+	QemuMemory string
+}
+
+// newProvisioner constructs the Provisioner for the requested kind.
+func newProvisioner(kind ProvisionerKind, opts ProvisionerOptions) (Provisioner, error) {
+	switch kind {
+	case ProvisionerNone, "":
+		return &noneProvisioner{
+			host:    opts.RemoteHost,
+			sshUser: opts.SshUser,
+			sshKey:  opts.SshKey,
+		}, nil
+	case ProvisionerHetzner:
+		return &hetznerProvisioner{
+			token:      opts.HetznerToken,
+			serverType: opts.HetznerServerType,
+			location:   opts.HetznerLocation,
+			image:      opts.HetznerImage,
+			sshKey:     opts.SshKey,
+			sshUser:    "root",
+		}, nil
+	case ProvisionerQemu:
+		return &qemuProvisioner{
+			image:   opts.QemuImage,
+			memory:  opts.QemuMemory,
+			sshKey:  opts.SshKey,
+			sshUser: opts.SshUser,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported provisioner: %q", kind)
+	}
+}
+
+// noneProvisioner assumes a host has already been provisioned out-of-band
+// and its address was passed via -remote-host/-ssh-user/-ssh-key. Create and
+// Destroy are no-ops. An empty host is a valid configuration: it means the
+// suite should install and run entirely on the local machine, matching
+// Installer.Install's localRun branch.
+type noneProvisioner struct {
+	host    string
+	sshUser string
+	sshKey  string
+}
+
+func (p *noneProvisioner) Create(ctx context.Context) (string, string, string, error) {
+	return p.host, p.sshUser, p.sshKey, nil
+}
+
+func (p *noneProvisioner) Destroy(ctx context.Context) error {
+	return nil
+}
+
+// hetznerProvisioner creates a throwaway Hetzner Cloud server for the
+// duration of the suite via the Hetzner Cloud API.
+type hetznerProvisioner struct {
+	token      string
+	serverType string
+	location   string
+	image      string
+	sshKey     string
+	sshUser    string
+
+	serverID float64
+	sshKeyID float64
+}
+
+const hetznerAPIBase = "https://api.hetzner.cloud/v1"
+
+func (p *hetznerProvisioner) Create(ctx context.Context) (string, string, string, error) {
+	if p.token == "" {
+		return "", "", "", fmt.Errorf("-hetzner-token (or HCLOUD_TOKEN) must be set when -provisioner=hetzner")
+	}
+
+	sshKeyID, err := p.ensureSSHKey(ctx)
+	if err != nil {
+		return "", "", "", err
+	}
+	p.sshKeyID = sshKeyID
+
+	body := map[string]any{
+		"name":        fmt.Sprintf("ctk-e2e-%d", time.Now().UnixNano()),
+		"server_type": p.serverType,
+		"location":    p.location,
+		"image":       p.image,
+		"ssh_keys":    []float64{sshKeyID},
+	}
+
+	var resp struct {
+		Server struct {
+			ID        float64 `json:"id"`
+			PublicNet struct {
+				IPv4 struct {
+					IP string `json:"ip"`
+				} `json:"ipv4"`
+			} `json:"public_net"`
+		} `json:"server"`
+	}
+
+	if err := p.do(ctx, http.MethodPost, "/servers", body, &resp); err != nil {
+		return "", "", "", fmt.Errorf("failed to create hetzner server: %w", err)
+	}
+	p.serverID = resp.Server.ID
+
+	host := resp.Server.PublicNet.IPv4.IP
+	if host == "" {
+		return "", "", "", fmt.Errorf("hetzner server %v has no public IPv4 address", p.serverID)
+	}
+
+	return host, p.sshUser, p.sshKey, nil
+}
+
+// ensureSSHKey registers the public key counterpart of -ssh-key (expected
+// alongside it as "<ssh-key>.pub", following ssh-keygen's convention) with
+// Hetzner Cloud so that the server created from it can be reached with
+// -ssh-key, and returns the registered key's ID.
+func (p *hetznerProvisioner) ensureSSHKey(ctx context.Context) (float64, error) {
+	pubKeyPath := p.sshKey + ".pub"
+	pubKey, err := os.ReadFile(pubKeyPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read public key %q (expected alongside -ssh-key): %w", pubKeyPath, err)
+	}
+
+	body := map[string]any{
+		"name":       fmt.Sprintf("ctk-e2e-%d", time.Now().UnixNano()),
+		"public_key": strings.TrimSpace(string(pubKey)),
+	}
+
+	var resp struct {
+		SSHKey struct {
+			ID float64 `json:"id"`
+		} `json:"ssh_key"`
+	}
+
+	if err := p.do(ctx, http.MethodPost, "/ssh_keys", body, &resp); err != nil {
+		return 0, fmt.Errorf("failed to register ssh key %q with hetzner: %w", pubKeyPath, err)
+	}
+
+	return resp.SSHKey.ID, nil
+}
+
+func (p *hetznerProvisioner) Destroy(ctx context.Context) error {
+	if p.serverID != 0 {
+		if err := p.do(ctx, http.MethodDelete, fmt.Sprintf("/servers/%v", p.serverID), nil, nil); err != nil {
+			return err
+		}
+	}
+	if p.sshKeyID != 0 {
+		if err := p.do(ctx, http.MethodDelete, fmt.Sprintf("/ssh_keys/%v", p.sshKeyID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *hetznerProvisioner) do(ctx context.Context, method, path string, reqBody, respBody any) error {
+	var bodyReader *bytes.Reader
+	if reqBody != nil {
+		encoded, err := json.Marshal(reqBody)
+		if err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+		bodyReader = bytes.NewReader(encoded)
+	} else {
+		bodyReader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, hetznerAPIBase+path, bodyReader)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("hetzner API returned status %d", resp.StatusCode)
+	}
+
+	if respBody != nil {
+		if err := json.NewDecoder(resp.Body).Decode(respBody); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// qemuProvisioner boots a local QEMU VM from a cloud image so that the suite
+// can be run entirely on a contributor's laptop.
+type qemuProvisioner struct {
+	image   string
+	memory  string
+	sshKey  string
+	sshUser string
+
+	workDir string
+	cmd     *exec.Cmd
+}
+
+func (p *qemuProvisioner) Create(ctx context.Context) (string, string, string, error) {
+	if p.image == "" {
+		return "", "", "", fmt.Errorf("-qemu-image must be set when -provisioner=qemu")
+	}
+
+	workDir, err := os.MkdirTemp("", "ctk_e2e_qemu_")
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to create qemu work dir: %w", err)
+	}
+	p.workDir = workDir
+
+	args := []string{
+		"-m", p.memory,
+		"-nographic",
+		"-drive", fmt.Sprintf("file=%s,if=virtio", p.image),
+		"-netdev", "user,id=net0,hostfwd=tcp::2222-:22",
+		"-device", "virtio-net-pci,netdev=net0",
+	}
+
+	cmd := exec.CommandContext(ctx, "qemu-system-x86_64", args...)
+	cmd.Dir = p.workDir
+	if err := cmd.Start(); err != nil {
+		return "", "", "", fmt.Errorf("failed to start qemu: %w", err)
+	}
+	p.cmd = cmd
+
+	return "127.0.0.1:2222", p.sshUser, p.sshKey, nil
+}
+
+func (p *qemuProvisioner) Destroy(ctx context.Context) error {
+	if p.cmd != nil && p.cmd.Process != nil {
+		if err := p.cmd.Process.Kill(); err != nil {
+			return fmt.Errorf("failed to kill qemu process: %w", err)
+		}
+	}
+	if p.workDir != "" {
+		return os.RemoveAll(p.workDir)
+	}
+	return nil
+}
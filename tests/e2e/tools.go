@@ -18,13 +18,33 @@ package e2e
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Runtime identifies the container runtime that the toolkit is installed and
+// configured against.
+type Runtime string
+
+const (
+	RuntimeDocker     Runtime = "docker"
+	RuntimeContainerd Runtime = "containerd"
+	RuntimeCrio       Runtime = "crio"
+	RuntimePodman     Runtime = "podman"
 )
 
 var dockerInstallTemplate = `
@@ -36,6 +56,7 @@ set -xe
 # Create a temporary directory
 TEMP_DIR="/tmp/ctk_e2e.$(date +%s)_$RANDOM"
 mkdir -p "$TEMP_DIR"
+echo "CTK_E2E_WORKDIR=$TEMP_DIR"
 
 # Given that docker has an init function that checks for the existence of the
 # nvidia-container-toolkit, we need to create a symlink to the nvidia-container-runtime-hook
@@ -57,39 +78,142 @@ docker run --pid=host --rm -i --privileged	\
 	--restart-mode=systemd
 `
 
+var containerdInstallTemplate = `
+#! /usr/bin/env bash
+set -xe
+
+: ${IMAGE:={{.Image}}}
+
+# Create a temporary directory
+TEMP_DIR="/tmp/ctk_e2e.$(date +%s)_$RANDOM"
+mkdir -p "$TEMP_DIR"
+echo "CTK_E2E_WORKDIR=$TEMP_DIR"
+
+docker run --pid=host --rm -i --privileged	\
+	-v /:/host	\
+	-v /run/containerd/containerd.sock:/run/containerd/containerd.sock	\
+	-v "$TEMP_DIR:$TEMP_DIR"	\
+	-v /etc/containerd:/config-root	\
+	${IMAGE}	\
+	--root "$TEMP_DIR"	\
+	--runtime=containerd	\
+	--config=/config-root/config.toml	\
+	--driver-root=/	\
+	--no-daemon	\
+	--restart-mode=systemd
+`
+
+var crioInstallTemplate = `
+#! /usr/bin/env bash
+set -xe
+
+: ${IMAGE:={{.Image}}}
+
+# Create a temporary directory
+TEMP_DIR="/tmp/ctk_e2e.$(date +%s)_$RANDOM"
+mkdir -p "$TEMP_DIR"
+echo "CTK_E2E_WORKDIR=$TEMP_DIR"
+
+docker run --pid=host --rm -i --privileged	\
+	-v /:/host	\
+	-v "$TEMP_DIR:$TEMP_DIR"	\
+	-v /etc/crio/crio.conf.d:/config-root	\
+	${IMAGE}	\
+	--root "$TEMP_DIR"	\
+	--runtime=crio	\
+	--config=/config-root/99-nvidia.conf	\
+	--driver-root=/	\
+	--no-daemon	\
+	--restart-mode=systemd
+`
+
+var podmanInstallTemplate = `
+#! /usr/bin/env bash
+set -xe
+
+: ${IMAGE:={{.Image}}}
+
+# Create a temporary directory
+TEMP_DIR="/tmp/ctk_e2e.$(date +%s)_$RANDOM"
+mkdir -p "$TEMP_DIR"
+echo "CTK_E2E_WORKDIR=$TEMP_DIR"
+
+# podman has no long-running daemon to restart, so the toolkit is installed
+# with restarts disabled.
+docker run --pid=host --rm -i --privileged	\
+	-v /:/host	\
+	-v "$TEMP_DIR:$TEMP_DIR"	\
+	-v /etc/containers:/config-root	\
+	${IMAGE}	\
+	--root "$TEMP_DIR"	\
+	--runtime=podman	\
+	--config=/config-root/containers.conf	\
+	--driver-root=/	\
+	--no-daemon	\
+	--restart-mode=none
+`
+
+// installTemplates maps each supported runtime to the bash script used to
+// install and configure the toolkit against it.
+var installTemplates = map[Runtime]string{
+	RuntimeDocker:     dockerInstallTemplate,
+	RuntimeContainerd: containerdInstallTemplate,
+	RuntimeCrio:       crioInstallTemplate,
+	RuntimePodman:     podmanInstallTemplate,
+}
+
 type Installer struct {
 	Image    string
 	Template string
-
-	SshKey     string
-	SshUser    string
-	RemoteHost string
+	Runtime  Runtime
+
+	SshKey         string
+	SshUser        string
+	RemoteHost     string
+	SshKnownHosts  string
+	ConnectRetries int
+	ConnectBackoff time.Duration
+
+	// WorkDir is the toolkit's scratch directory on the installed host,
+	// populated by Install once the script has run.
+	WorkDir string
 }
 
-func newInstaller(templateStr, image, sshKey, sshUser, remoteHost string) (Installer, error) {
+func newInstaller(runtime Runtime, image, sshKey, sshUser, remoteHost, sshKnownHosts string, connectRetries int, connectBackoff time.Duration) (Installer, error) {
+	templateStr, ok := installTemplates[runtime]
+	if !ok {
+		return Installer{}, fmt.Errorf("unsupported runtime: %q", runtime)
+	}
+
 	i := Installer{
-		Image:      image,
-		Template:   templateStr,
-		SshKey:     sshKey,
-		SshUser:    sshUser,
-		RemoteHost: remoteHost,
+		Image:          image,
+		Template:       templateStr,
+		Runtime:        runtime,
+		SshKey:         sshKey,
+		SshUser:        sshUser,
+		RemoteHost:     remoteHost,
+		SshKnownHosts:  sshKnownHosts,
+		ConnectRetries: connectRetries,
+		ConnectBackoff: connectBackoff,
 	}
 
 	return i, nil
 }
 
-func (i *Installer) Install() error {
+// Install renders the Installer's template and runs it locally or over SSH,
+// teeing its output to stdout/stderr as it is produced.
+func (i *Installer) Install(ctx context.Context, stdout, stderr io.Writer) (*ScriptResult, error) {
 	// Parse the combined template
 	tmpl, err := template.New("dockerScript").Parse(i.Template)
 	if err != nil {
-		return fmt.Errorf("error parsing template: %w", err)
+		return nil, fmt.Errorf("error parsing template: %w", err)
 	}
 
 	// Execute the template
 	var renderedScript bytes.Buffer
 	err = tmpl.Execute(&renderedScript, i)
 	if err != nil {
-		return fmt.Errorf("error executing template: %w", err)
+		return nil, fmt.Errorf("error executing template: %w", err)
 	}
 
 	var s Script
@@ -97,111 +221,315 @@ func (i *Installer) Install() error {
 	case i.RemoteHost == "":
 		s = localRun{}
 	default:
-		s = remoteRun{i.SshKey, i.SshUser, i.RemoteHost}
+		s = remoteRun{i.SshKey, i.SshUser, i.RemoteHost, i.SshKnownHosts, i.ConnectRetries, i.ConnectBackoff}
+	}
+
+	result, err := s.Run(ctx, renderedScript.String(), stdout, stderr)
+	if result != nil {
+		i.WorkDir = parseWorkDir(result.Stdout)
+	}
+	return result, err
+}
+
+// workDirMarker is printed by each install template once it has created its
+// scratch directory, so Install can recover that path for later use (e.g.
+// rooting a post-failure debug shell in it).
+const workDirMarker = "CTK_E2E_WORKDIR="
+
+func parseWorkDir(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, workDirMarker) {
+			return strings.TrimSpace(strings.TrimPrefix(line, workDirMarker))
+		}
 	}
+	return ""
+}
 
-	_, err = s.Run(renderedScript.String())
-	return err
+// ScriptResult captures everything observed while a Script ran.
+type ScriptResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
 }
 
+// Script runs a bash script, streaming its stdout/stderr to the given
+// writers as it is produced, and returns a ScriptResult describing what
+// happened. Run must return promptly once ctx is cancelled, killing the
+// underlying process if necessary.
 type Script interface {
-	Run(script string) (string, error)
+	Run(ctx context.Context, script string, stdout, stderr io.Writer) (*ScriptResult, error)
 }
 
 type localRun struct{}
 type remoteRun struct {
-	SshKey     string
-	SshUser    string
-	RemoteHost string
+	SshKey         string
+	SshUser        string
+	RemoteHost     string
+	SshKnownHosts  string
+	ConnectRetries int
+	ConnectBackoff time.Duration
 }
 
-func (l localRun) Run(script string) (string, error) {
-	// Create a command to run the script using bash
+func (l localRun) Run(ctx context.Context, script string, stdout, stderr io.Writer) (*ScriptResult, error) {
 	cmd := exec.Command("bash", "-c", script)
 
-	// Buffer to capture standard output
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdoutBuf, stdout)
+	cmd.Stderr = io.MultiWriter(&stderrBuf, stderr)
 
-	// Buffer to capture standard error
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start script: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		_ = cmd.Process.Kill()
+		<-done
+		err = ctx.Err()
+	case err = <-done:
+	}
+
+	result := &ScriptResult{
+		Stdout:   stdoutBuf.String(),
+		Stderr:   stderrBuf.String(),
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Duration: time.Since(start),
+	}
 
-	// Run the command
-	err := cmd.Run()
 	if err != nil {
-		return "", fmt.Errorf("script execution failed: %v\nSTDOUT: %s\nSTDERR: %s", err, stdout.String(), stderr.String())
+		return result, fmt.Errorf("script execution failed: %w\nSTDOUT: %s\nSTDERR: %s", err, result.Stdout, result.Stderr)
 	}
 
-	// Return the captured stdout and nil error
-	return stdout.String(), nil
+	return result, nil
 }
 
-func (r remoteRun) Run(script string) (string, error) {
+func (r remoteRun) Run(ctx context.Context, script string, stdout, stderr io.Writer) (*ScriptResult, error) {
 	// Create a new SSH connection
-	client, err := connectOrDie(r.SshKey, r.SshUser, r.RemoteHost)
+	client, err := connectOrDie(r.SshKey, r.SshUser, r.RemoteHost, r.SshKnownHosts, r.ConnectRetries, r.ConnectBackoff)
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to %s: %v", r.RemoteHost, err)
+		return nil, fmt.Errorf("failed to connect to %s: %v", r.RemoteHost, err)
 	}
 	defer client.Close()
 
 	// Create a session
 	session, err := client.NewSession()
 	if err != nil {
-		return "", fmt.Errorf("failed to create session: %v", err)
+		return nil, fmt.Errorf("failed to create session: %v", err)
 	}
 	defer session.Close()
 
-	// Capture stdout and stderr
-	var stdout, stderr bytes.Buffer
-	session.Stdout = &stdout
-	session.Stderr = &stderr
+	stdoutPipe, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	stderrPipe, err := session.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stderr pipe: %w", err)
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(io.MultiWriter(&stdoutBuf, stdout), stdoutPipe)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(io.MultiWriter(&stderrBuf, stderr), stderrPipe)
+	}()
+
+	start := time.Now()
+	if err := session.Start(script); err != nil {
+		return nil, fmt.Errorf("failed to start script: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		<-done
+		err = ctx.Err()
+	case err = <-done:
+	}
+
+	wg.Wait()
+
+	result := &ScriptResult{
+		Stdout:   stdoutBuf.String(),
+		Stderr:   stderrBuf.String(),
+		Duration: time.Since(start),
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitStatus()
+	}
 
-	// Run the script
-	err = session.Run(script)
 	if err != nil {
-		return "", fmt.Errorf("script execution failed: %v\nSTDOUT: %s\nSTDERR: %s", err, stdout.String(), stderr.String())
+		return result, fmt.Errorf("script execution failed: %w\nSTDOUT: %s\nSTDERR: %s", err, result.Stdout, result.Stderr)
 	}
 
-	// Return stdout as string if no errors
-	return stdout.String(), nil
+	return result, nil
 }
 
 // createSshClient creates a ssh client, and retries if it fails to connect
-func connectOrDie(sshKey, sshUser, remoteHost string) (*ssh.Client, error) {
+func connectOrDie(sshKey, sshUser, remoteHost, sshKnownHosts string, retries int, backoff time.Duration) (*ssh.Client, error) {
+	if retries <= 0 {
+		retries = 20
+	}
+	if backoff <= 0 {
+		backoff = 1 * time.Second
+	}
+
 	var client *ssh.Client
 	var err error
-	key, err := os.ReadFile(sshKey)
+
+	auth, err := sshAuthMethods(sshKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read key file: %v", err)
+		return nil, err
 	}
-	signer, err := ssh.ParsePrivateKey(key)
+
+	hostKeyCallback, err := hostKeyCallback(sshKnownHosts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %v", err)
+		return nil, err
 	}
+
 	sshConfig := &ssh.ClientConfig{
-		User: sshUser,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            sshUser,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	addr := remoteHost
+	if _, _, splitErr := net.SplitHostPort(remoteHost); splitErr != nil {
+		addr = remoteHost + ":22"
 	}
 
 	connectionFailed := false
-	for i := 0; i < 20; i++ {
-		client, err = ssh.Dial("tcp", remoteHost+":22", sshConfig)
+	for i := 0; i < retries; i++ {
+		client, err = ssh.Dial("tcp", addr, sshConfig)
 		if err == nil {
 			return client, nil // Connection succeeded, return the client.
 		}
 		connectionFailed = true
 		// Sleep for a brief moment before retrying.
 		// You can adjust the duration based on your requirements.
-		time.Sleep(1 * time.Second)
+		time.Sleep(backoff)
 	}
 
 	if connectionFailed {
-		return nil, fmt.Errorf("failed to connect to %s after 10 retries, giving up", remoteHost)
+		return nil, fmt.Errorf("failed to connect to %s after %d retries, giving up: %v", remoteHost, retries, err)
 	}
 
 	return client, nil
 }
+
+// sshAuthMethods builds the list of ssh.AuthMethods to offer when
+// connecting. If sshKey is set, it is parsed and offered as a public key. The
+// ssh-agent at $SSH_AUTH_SOCK, if reachable, is always offered as an
+// additional method so that it can be used on its own when no key file is
+// given.
+func sshAuthMethods(sshKey string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if sshKey != "" {
+		key, err := os.ReadFile(sshKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			agentClient := agent.NewClient(conn)
+			methods = append(methods, ssh.PublicKeysCallback(agentClient.Signers))
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh authentication method available: set -ssh-key or SSH_AUTH_SOCK")
+	}
+
+	return methods, nil
+}
+
+// hostKeyCallback builds a HostKeyCallback that verifies the remote host
+// against the given known_hosts file. When sshKnownHosts is empty, a
+// default file under the user's home directory is used and unknown hosts
+// are trusted on first connect (TOFU) and recorded for next time, since e2e
+// hosts are frequently freshly provisioned and have no pre-populated entry.
+// A host whose key has changed since it was first recorded is still
+// rejected.
+func hostKeyCallback(sshKnownHosts string) (ssh.HostKeyCallback, error) {
+	trustOnFirstUse := sshKnownHosts == ""
+	if trustOnFirstUse {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine home directory for default known_hosts path: %w", err)
+		}
+		sshKnownHosts = filepath.Join(home, ".ssh", "ctk_e2e_known_hosts")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sshKnownHosts), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+	if _, err := os.OpenFile(sshKnownHosts, os.O_CREATE, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to create known_hosts file %q: %w", sshKnownHosts, err)
+	}
+
+	verify, err := knownhosts.New(sshKnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts file %q: %v", sshKnownHosts, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := verify(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !trustOnFirstUse || !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			// An explicitly given known_hosts file is authoritative, an
+			// unexpected error occurred, or the host key changed from one
+			// we already trusted: refuse to proceed in every case.
+			return err
+		}
+
+		// We've never seen this host before: trust it and record it so
+		// later connections are verified against this exact key.
+		return recordHostKey(sshKnownHosts, hostname, key)
+	}, nil
+}
+
+// recordHostKey appends hostname's key to the known_hosts file at path,
+// completing a trust-on-first-use decision.
+func recordHostKey(path, hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to record new host key for %s: %w", hostname, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to record new host key for %s: %w", hostname, err)
+	}
+
+	return nil
+}